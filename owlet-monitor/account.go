@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+)
+
+// DeviceState is the most recently decoded vitals sample for one DSN, as
+// exposed by the /api/v1/devices and /api/v1/vitals/{dsn} endpoints.
+type DeviceState struct {
+	Account   string    `json:"account"`
+	DSN       string    `json:"dsn"`
+	Vitals    Vitals    `json:"vitals"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DeviceEvent is emitted on the /api/v1/events stream whenever a device's
+// vitals change.
+type DeviceEvent struct {
+	Account string      `json:"account"`
+	DSN     string      `json:"dsn"`
+	State   DeviceState `json:"state"`
+}
+
+// Account owns all mutable session state for one Owlet account: its
+// credentials, the Ayla auth token, the discovered DSNs, and the last
+// vitals sample per device. A process polls one Account per entry in
+// Config.Accounts, each on its own goroutine, so replacing the old
+// package-level globals with this struct also keeps one account's state
+// from leaking into another's.
+type Account struct {
+	name       string
+	cfg        *Config
+	accountCfg AccountConfig
+	httpClient *http.Client
+	breaker    *CircuitBreaker
+
+	scrapeErrors prometheus.Counter
+	authFailures prometheus.Counter
+	pollDuration prometheus.Observer
+
+	mu         sync.RWMutex
+	authToken  string
+	expireTime time.Time
+	region     string
+	dsns       []string
+	dsnReady   bool
+	devices    map[string]DeviceState
+
+	subMu sync.Mutex
+	subs  map[chan DeviceEvent]struct{}
+}
+
+// circuitThreshold/circuitCooldown govern when polling backs off after
+// repeated failures: five consecutive failed cycles opens the breaker,
+// which then lets one probe cycle through every 30s until it succeeds.
+const (
+	circuitThreshold = 5
+	circuitCooldown  = 30 * time.Second
+)
+
+// newAccount builds the Account for one entry of Config.Accounts, currying
+// its Prometheus handles to accountCfg.Name so every metric this account
+// touches is pre-labelled and callers never have to pass the account name
+// around by hand. Each account gets its own *http.Client rather than
+// sharing one across accounts, so a future per-account proxy or timeout
+// override has somewhere to land.
+func newAccount(cfg *Config, accountCfg AccountConfig) *Account {
+	name := accountCfg.Name
+	return &Account{
+		name:         name,
+		cfg:          cfg,
+		accountCfg:   accountCfg,
+		httpClient:   &http.Client{Timeout: cfg.HTTPTimeout.Duration},
+		breaker:      newCircuitBreaker(circuitThreshold, circuitCooldown, circuitStateVec.WithLabelValues(name)),
+		scrapeErrors: scrapeErrorsVec.WithLabelValues(name),
+		authFailures: authFailuresVec.WithLabelValues(name),
+		pollDuration: pollDurationVec.WithLabelValues(name),
+		region:       accountCfg.Region,
+		devices:      make(map[string]DeviceState),
+		subs:         make(map[chan DeviceEvent]struct{}),
+	}
+}
+
+// Authenticated reports whether the account currently holds a non-expired
+// auth token, for /healthz.
+func (a *Account) Authenticated() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.authToken != "" && time.Now().Before(a.expireTime)
+}
+
+// Ready reports whether fetchDSN has completed successfully at least
+// once, for /readyz.
+func (a *Account) Ready() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.dsnReady
+}
+
+// Devices returns a snapshot of the last known state for every DSN
+// discovered under this account, for /api/v1/devices.
+func (a *Account) Devices() []DeviceState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make([]DeviceState, 0, len(a.devices))
+	for _, d := range a.devices {
+		out = append(out, d)
+	}
+	return out
+}
+
+// DeviceState returns the last known state for a single DSN, for
+// /api/v1/vitals/{dsn}.
+func (a *Account) DeviceState(dsn string) (DeviceState, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	d, ok := a.devices[dsn]
+	return d, ok
+}
+
+// Subscribe registers a channel that receives a DeviceEvent whenever a
+// device's vitals change, for the /api/v1/events stream. The returned
+// func must be called to unregister the channel once the caller is done.
+func (a *Account) Subscribe() (chan DeviceEvent, func()) {
+	ch := make(chan DeviceEvent, 8)
+	a.subMu.Lock()
+	a.subs[ch] = struct{}{}
+	a.subMu.Unlock()
+	return ch, func() {
+		a.subMu.Lock()
+		delete(a.subs, ch)
+		a.subMu.Unlock()
+		close(ch)
+	}
+}
+
+func (a *Account) publish(ev DeviceEvent) {
+	a.subMu.Lock()
+	defer a.subMu.Unlock()
+	for ch := range a.subs {
+		select {
+		case ch <- ev:
+		default: // slow subscriber, drop rather than block the poller
+		}
+	}
+}
+
+// login retries loginOnce with full-jittered exponential backoff so a
+// transient failure against Firebase or Ayla doesn't immediately bubble up
+// to the caller.
+func (a *Account) login(ctx context.Context) error {
+	if a.Authenticated() {
+		return nil
+	}
+	return withRetry(ctx, fmt.Sprintf("login(%s)", a.name), func() error { return a.loginOnce(ctx) })
+}
+
+func (a *Account) loginOnce(ctx context.Context) error {
+	if a.Authenticated() {
+		return nil
+	}
+
+	log.Printf("[%s] Logging in", a.name)
+
+	owletUser := a.accountCfg.OwletUser
+	owletPass := a.accountCfg.OwletPass
+	a.mu.Lock()
+	a.region = a.accountCfg.Region
+	region := a.region
+	a.mu.Unlock()
+
+	if owletUser == "" || owletPass == "" {
+		return fmt.Errorf("account %q: owletUser or owletPass is not set", a.name)
+	}
+	conf, ok := regionConfs[region]
+	if !ok {
+		return fmt.Errorf("account %q: region %q not recognised", a.name, region)
+	}
+
+	// 1. Authenticate against Firebase
+	gAuthReqBody, _ := json.Marshal(GoogleAuthRequest{
+		Email:             owletUser,
+		Password:          owletPass,
+		ReturnSecureToken: true,
+	})
+	req, _ := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("https://www.googleapis.com/identitytoolkit/v3/relyingparty/verifyPassword?key=%s", conf.APIKey), bytes.NewBuffer(gAuthReqBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Android-Package", "com.owletcare.owletcare")
+	req.Header.Set("X-Android-Cert", "2A3BC26DB0B8B0792DBE28E6FFDC2598F9B12B74")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("google auth failed with status: %s", resp.Status)
+	}
+	var gAuthResp GoogleAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&gAuthResp); err != nil {
+		return err
+	}
+	jwt := gAuthResp.IDToken
+
+	// 2. Get mini_token
+	req, _ = http.NewRequestWithContext(ctx, "GET", conf.URLMini, nil)
+	req.Header.Set("Authorization", jwt)
+	req.Header.Set("Accept", "application/json")
+	resp, err = a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mini token fetch failed with status: %s", resp.Status)
+	}
+	var miniTokenResp MiniTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&miniTokenResp); err != nil {
+		return err
+	}
+	miniToken := miniTokenResp.MiniToken
+
+	// 3. Get Ayla access_token
+	aylaAuthReqBody, _ := json.Marshal(AylaAuthRequest{
+		AppID:     conf.AppID,
+		AppSecret: conf.AppSecret,
+		Provider:  "owl_id",
+		Token:     miniToken,
+	})
+	req, _ = http.NewRequestWithContext(ctx, "POST", conf.URLSignin, bytes.NewBuffer(aylaAuthReqBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err = a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		a.mu.Lock()
+		a.authToken = ""
+		a.mu.Unlock()
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ayla auth failed with status: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+	var aylaAuthResp AylaAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&aylaAuthResp); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.authToken = aylaAuthResp.AccessToken
+	a.expireTime = time.Now().Add(time.Duration(aylaAuthResp.ExpiresIn)*time.Second - a.cfg.ReauthBuffer.Duration)
+	expireTime := a.expireTime
+	a.mu.Unlock()
+	log.Printf("[%s] Auth token obtained, valid until %s", a.name, expireTime.Format(time.RFC1123))
+	return nil
+}
+
+// fetchDSN retries fetchDSNOnce with full-jittered exponential backoff.
+func (a *Account) fetchDSN(ctx context.Context) error {
+	if a.Ready() {
+		return nil
+	}
+	return withRetry(ctx, fmt.Sprintf("fetchDSN(%s)", a.name), func() error { return a.fetchDSNOnce(ctx) })
+}
+
+func (a *Account) fetchDSNOnce(ctx context.Context) error {
+	if a.Ready() {
+		return nil
+	}
+	log.Printf("[%s] Getting DSN", a.name)
+
+	a.mu.RLock()
+	conf := regionConfs[a.region]
+	token := a.authToken
+	a.mu.RUnlock()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", conf.URLBase+"/devices.json", nil)
+	req.Header.Set("Authorization", "auth_token "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		a.mu.Lock()
+		a.authToken = ""
+		a.mu.Unlock()
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("fetch dsn failed with status: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+
+	var devicesResp []DeviceContainer
+	if err := json.NewDecoder(resp.Body).Decode(&devicesResp); err != nil {
+		return err
+	}
+
+	if len(devicesResp) == 0 {
+		return fmt.Errorf("account %q: found zero Owlet monitors", a.name)
+	}
+
+	dsns := make([]string, 0, len(devicesResp))
+	for _, dev := range devicesResp {
+		dsns = append(dsns, dev.Device.DSN)
+		log.Printf("[%s] Found Owlet monitor device serial number %s", a.name, dev.Device.DSN)
+	}
+
+	a.mu.Lock()
+	a.dsns = dsns
+	a.dsnReady = true
+	a.mu.Unlock()
+	return nil
+}
+
+// reactivate retries reactivateOnce with full-jittered exponential backoff.
+func (a *Account) reactivate(ctx context.Context, dsn string) error {
+	return withRetry(ctx, fmt.Sprintf("reactivate(%s/%s)", a.name, dsn), func() error { return a.reactivateOnce(ctx, dsn) })
+}
+
+func (a *Account) reactivateOnce(ctx context.Context, dsn string) error {
+	a.mu.RLock()
+	conf := regionConfs[a.region]
+	token := a.authToken
+	a.mu.RUnlock()
+
+	url := fmt.Sprintf("%s/dsns/%s/properties/APP_ACTIVE/datapoints.json", conf.URLBase, dsn)
+	payload := `{"datapoint":{"metadata":{},"value":1}}`
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBufferString(payload))
+	req.Header.Set("Authorization", "auth_token "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		a.mu.Lock()
+		a.authToken = ""
+		a.mu.Unlock()
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("reactivate failed for DSN %s with status: %s, body: %s", dsn, resp.Status, string(bodyBytes))
+		if resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("%w: %v", errAuthExpired, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// fetchProps reactivates and fetches properties.json for every DSN on this
+// account, fanning the work out across up to cfg.MaxConcurrentDSNs
+// goroutines at once instead of polling one DSN at a time.
+func (a *Account) fetchProps(ctx context.Context) (map[string]map[string]Property, error) {
+	a.mu.RLock()
+	dsns := append([]string(nil), a.dsns...)
+	a.mu.RUnlock()
+
+	var mu sync.Mutex
+	allProps := make(map[string]map[string]Property)
+
+	limit := a.cfg.MaxConcurrentDSNs
+	if limit <= 0 {
+		limit = 1
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(limit)
+
+	for _, dsn := range dsns {
+		dsn := dsn
+		g.Go(func() error {
+			if err := a.reactivate(gctx, dsn); err != nil {
+				log.Printf("[%s] Warning: could not reactivate DSN %s: %v", a.name, dsn, err)
+				a.scrapeErrors.Inc()
+				return nil // one DSN's failure shouldn't abort the others
+			}
+
+			var deviceProps map[string]Property
+			err := withRetry(gctx, fmt.Sprintf("properties(%s/%s)", a.name, dsn), func() error {
+				props, err := a.fetchDeviceProps(gctx, dsn)
+				if err != nil {
+					return err
+				}
+				deviceProps = props
+				return nil
+			})
+			if err != nil {
+				log.Printf("[%s] Warning: could not fetch props for DSN %s: %v", a.name, dsn, err)
+				a.scrapeErrors.Inc()
+				return nil
+			}
+
+			mu.Lock()
+			allProps[dsn] = deviceProps
+			mu.Unlock()
+			return nil
+		})
+	}
+	// Every branch above returns nil so the whole account keeps polling its
+	// other DSNs even when one of them fails; g.Wait() only ever reports
+	// ctx cancellation.
+	if err := g.Wait(); err != nil {
+		return allProps, err
+	}
+	return allProps, nil
+}
+
+// fetchDeviceProps performs a single GET of a device's properties.json,
+// the "property GET" withRetry wraps on every poll.
+func (a *Account) fetchDeviceProps(ctx context.Context, dsn string) (map[string]Property, error) {
+	a.mu.RLock()
+	conf := regionConfs[a.region]
+	token := a.authToken
+	a.mu.RUnlock()
+
+	url := fmt.Sprintf("%s/dsns/%s/properties.json", conf.URLBase, dsn)
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req.Header.Set("Authorization", "auth_token "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		a.mu.Lock()
+		a.authToken = ""
+		a.mu.Unlock()
+	}
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("fetch props for DSN %s returned status: %s, body: %s", dsn, resp.Status, string(bodyBytes))
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("%w: %v", errAuthExpired, err)
+		}
+		return nil, err
+	}
+
+	var props []PropertyContainer
+	if err := json.NewDecoder(resp.Body).Decode(&props); err != nil {
+		return nil, fmt.Errorf("could not decode props for DSN %s: %w", dsn, err)
+	}
+
+	deviceProps := make(map[string]Property)
+	for _, p := range props {
+		deviceProps[p.Property.Name] = p.Property
+	}
+	return deviceProps, nil
+}
+
+// recordVitals decodes the REAL_TIME_VITALS property, updates the
+// Prometheus gauges, stores the result in the state store, and publishes
+// a DeviceEvent if it differs from the previously stored sample.
+func (a *Account) recordVitals(dsn string, props map[string]Property) {
+	rtv, ok := props["REAL_TIME_VITALS"]
+	if !ok || rtv.Value == nil {
+		log.Printf("[%s] no vitals for DSN %s", a.name, dsn)
+		return
+	}
+
+	valueStr, ok := rtv.Value.(string)
+	if !ok {
+		log.Printf("[%s] vitals value is not a string for DSN %s", a.name, dsn)
+		return
+	}
+
+	var vitals Vitals
+	// The value from the API is a JSON string, so we unmarshal it into our struct.
+	if err := json.Unmarshal([]byte(valueStr), &vitals); err != nil {
+		log.Printf("[%s] Warning: could not unmarshal vitals JSON: %v", a.name, err)
+		// As a fallback, print the raw string if unmarshaling fails.
+		fmt.Printf("[%s] %s: %s\n", a.name, getFormattedTime(rtv.DataUpdatedAt), valueStr)
+		return
+	}
+
+	recordVitalsMetrics(a.name, dsn, vitals, rtv.DataUpdatedAt)
+
+	updatedAt := time.Now()
+	if t, err := time.Parse(time.RFC3339Nano, rtv.DataUpdatedAt); err == nil {
+		updatedAt = t
+	}
+	state := DeviceState{Account: a.name, DSN: dsn, Vitals: vitals, UpdatedAt: updatedAt}
+
+	a.mu.Lock()
+	prev, existed := a.devices[dsn]
+	a.devices[dsn] = state
+	a.mu.Unlock()
+
+	if !existed || !prev.UpdatedAt.Equal(state.UpdatedAt) {
+		a.publish(DeviceEvent{Account: a.name, DSN: dsn, State: state})
+	}
+
+	prettyJSON, err := json.MarshalIndent(vitals, "", "  ")
+	if err != nil {
+		log.Fatalf("failed to marshal JSON: %s", err)
+	}
+
+	// Using %+v will print the struct with field names for better readability.
+	fmt.Printf("[%s] %s: %s\n", a.name, getFormattedTime(rtv.DataUpdatedAt), prettyJSON)
+}
+
+// loop drives the polling cycle for this account until ctx is cancelled
+// (e.g. on SIGTERM). login and fetchDSN are retried on every tick inside
+// runMonitoringCycle rather than just once up front, so an account that
+// fails to authenticate or discover devices on startup keeps retrying
+// (breaker-gated, same as any other poll failure) instead of permanently
+// exiting its goroutine — one misconfigured account shouldn't need a
+// process restart to self-heal, and shouldn't take the rest down with it.
+func (a *Account) loop(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.PollInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		// Initial run without waiting for ticker
+		a.runMonitoringCycle(ctx)
+
+		// Wait for next tick
+		select {
+		case <-ticker.C:
+			continue
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runMonitoringCycle is one poll tick. It's gated by the circuit breaker
+// so a run of consecutive failures stops hammering Ayla and instead waits
+// for the cooldown before letting a single probe cycle through.
+func (a *Account) runMonitoringCycle(ctx context.Context) {
+	if !a.breaker.Allow() {
+		log.Printf("[%s] circuit breaker open, skipping poll", a.name)
+		return
+	}
+
+	start := time.Now()
+	err := a.runMonitoringCycleOnce(ctx)
+	a.pollDuration.Observe(time.Since(start).Seconds())
+	a.breaker.RecordResult(err)
+}
+
+func (a *Account) runMonitoringCycleOnce(ctx context.Context) error {
+	if err := a.login(ctx); err != nil { // Check token and re-login if needed
+		log.Printf("[%s] Error during periodic login check: %v", a.name, err)
+		a.scrapeErrors.Inc()
+		a.authFailures.Inc()
+		return err
+	}
+
+	if err := a.fetchDSN(ctx); err != nil { // Discover DSNs if we haven't yet
+		log.Printf("[%s] Error discovering devices: %v", a.name, err)
+		a.scrapeErrors.Inc()
+		return err
+	}
+
+	allDeviceProps, err := a.fetchProps(ctx)
+	if err != nil {
+		log.Printf("[%s] Error fetching props: %v", a.name, err)
+		return err
+	}
+
+	for dsn, props := range allDeviceProps {
+		a.recordVitals(dsn, props)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+// App aggregates every configured Account so the HTTP API in server.go can
+// be served from a single address regardless of how many accounts are
+// being polled.
+type App struct {
+	accounts []*Account
+}
+
+func newApp(accounts []*Account) *App {
+	return &App{accounts: accounts}
+}
+
+// Authenticated reports whether at least one account currently holds a
+// non-expired auth token, for /healthz. A single misconfigured or
+// permanently-failing account shouldn't sink the whole exporter's health
+// while its siblings are authenticating fine.
+func (app *App) Authenticated() bool {
+	for _, acc := range app.accounts {
+		if acc.Authenticated() {
+			return true
+		}
+	}
+	return len(app.accounts) == 0
+}
+
+// Ready reports whether at least one account has discovered at least one
+// device, for /readyz, for the same reason Authenticated doesn't require
+// unanimity.
+func (app *App) Ready() bool {
+	for _, acc := range app.accounts {
+		if acc.Ready() {
+			return true
+		}
+	}
+	return len(app.accounts) == 0
+}
+
+// Devices returns a snapshot of the last known state for every DSN across
+// every account, for /api/v1/devices.
+func (app *App) Devices() []DeviceState {
+	var out []DeviceState
+	for _, acc := range app.accounts {
+		out = append(out, acc.Devices()...)
+	}
+	return out
+}
+
+// DeviceState returns the last known state for a single DSN, searching
+// every account in turn, for /api/v1/vitals/{dsn}.
+func (app *App) DeviceState(dsn string) (DeviceState, bool) {
+	for _, acc := range app.accounts {
+		if state, ok := acc.DeviceState(dsn); ok {
+			return state, true
+		}
+	}
+	return DeviceState{}, false
+}
+
+// Subscribe merges the DeviceEvent stream of every account into one
+// channel, for /api/v1/events. The returned func unsubscribes from all of
+// them.
+func (app *App) Subscribe() (chan DeviceEvent, func()) {
+	out := make(chan DeviceEvent, 8*len(app.accounts))
+	stop := make(chan struct{})
+
+	unsubs := make([]func(), 0, len(app.accounts))
+	for _, acc := range app.accounts {
+		ch, unsub := acc.Subscribe()
+		unsubs = append(unsubs, unsub)
+
+		go func(ch chan DeviceEvent) {
+			for {
+				select {
+				case ev, ok := <-ch:
+					if !ok {
+						return
+					}
+					select {
+					case out <- ev:
+					default: // slow subscriber, drop rather than block the account
+					}
+				case <-stop:
+					return
+				}
+			}
+		}(ch)
+	}
+
+	return out, func() {
+		close(stop)
+		for _, unsub := range unsubs {
+			unsub()
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration wraps time.Duration so config values can be expressed as
+// human-readable strings (e.g. "30s", "5m") instead of raw nanoseconds,
+// the same approach dex uses for its Expiry block.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	if s == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// AccountConfig describes one Owlet account to poll. A process can list
+// several of these under Config.Accounts (e.g. one parent with a US
+// account and another with an EU account, or multiple caregivers sharing
+// one exporter).
+type AccountConfig struct {
+	Name string `json:"name" yaml:"name"`
+
+	Region      string `json:"region" yaml:"region"`
+	OwletUser   string `json:"owletUser" yaml:"owletUser"`
+	UserFromEnv string `json:"userFromEnv" yaml:"userFromEnv"`
+	OwletPass   string `json:"owletPass" yaml:"owletPass"`
+	PassFromEnv string `json:"passFromEnv" yaml:"passFromEnv"`
+}
+
+// Config is the top level configuration for owlet-monitor, loaded from the
+// JSON or YAML file passed via --config (format is picked by the file's
+// extension, defaulting to JSON). Anything left unset falls back to the
+// defaults below, and credentials/region can still be supplied through
+// OWLET_USER / OWLET_PASS / OWLET_REGION for deployments that don't want a
+// config file at all.
+type Config struct {
+	PollInterval      Duration                `json:"pollInterval" yaml:"pollInterval"`
+	ReauthBuffer      Duration                `json:"reauthBuffer" yaml:"reauthBuffer"`
+	HTTPTimeout       Duration                `json:"httpTimeout" yaml:"httpTimeout"`
+	MetricsAddr       string                  `json:"metricsAddr" yaml:"metricsAddr"`
+	Region            string                  `json:"region" yaml:"region"`
+	ExtraRegions      map[string]RegionConfig `json:"extraRegions" yaml:"extraRegions"`
+	MaxConcurrentDSNs int                     `json:"maxConcurrentDSNs" yaml:"maxConcurrentDSNs"`
+
+	// Accounts lists every account to poll. If empty, loadConfig
+	// synthesizes a single entry from the legacy OwletUser/OwletPass/
+	// Region fields below, so single-account config files keep working.
+	Accounts []AccountConfig `json:"accounts" yaml:"accounts"`
+
+	// OwletUser/OwletPass can be set inline, or left blank and resolved
+	// from the env var named by UserFromEnv/PassFromEnv so secrets don't
+	// have to live in the config file itself.
+	OwletUser   string `json:"owletUser" yaml:"owletUser"`
+	UserFromEnv string `json:"userFromEnv" yaml:"userFromEnv"`
+	OwletPass   string `json:"owletPass" yaml:"owletPass"`
+	PassFromEnv string `json:"passFromEnv" yaml:"passFromEnv"`
+}
+
+// defaultConfig returns the configuration used when no --config file is
+// supplied, preserving the previous hardcoded behaviour.
+func defaultConfig() *Config {
+	return &Config{
+		PollInterval:      Duration{5 * time.Second},
+		ReauthBuffer:      Duration{60 * time.Second},
+		HTTPTimeout:       Duration{30 * time.Second},
+		MetricsAddr:       ":9090",
+		Region:            "europe",
+		MaxConcurrentDSNs: 4,
+	}
+}
+
+// resolveSecret returns inline if set, otherwise the value of the env var
+// named by fromEnv, otherwise the value of the legacy env var for
+// backwards compatibility with deployments that only ever set OWLET_USER
+// or OWLET_PASS.
+func resolveSecret(inline, fromEnv, legacyEnv string) (string, error) {
+	if inline != "" {
+		return inline, nil
+	}
+	if fromEnv != "" {
+		v := os.Getenv(fromEnv)
+		if v == "" {
+			return "", fmt.Errorf("env var %q referenced by config is not set", fromEnv)
+		}
+		return v, nil
+	}
+	return os.Getenv(legacyEnv), nil
+}
+
+// loadConfig reads and parses the config file at path, if any, applies the
+// OWLET_REGION override, and resolves owletUser/owletPass down to concrete
+// values. The file may be JSON or YAML; format is picked by extension
+// (.yaml/.yml vs anything else), since a Config parsed from either should
+// behave identically.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		cfg = defaultConfig()
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			if err := yaml.Unmarshal(data, cfg); err != nil {
+				return nil, fmt.Errorf("parsing config file: %w", err)
+			}
+		default:
+			if err := json.Unmarshal(data, cfg); err != nil {
+				return nil, fmt.Errorf("parsing config file: %w", err)
+			}
+		}
+	}
+
+	if env := os.Getenv("OWLET_REGION"); env != "" {
+		cfg.Region = env
+	}
+
+	user, err := resolveSecret(cfg.OwletUser, cfg.UserFromEnv, "OWLET_USER")
+	if err != nil {
+		return nil, fmt.Errorf("resolving owlet user: %w", err)
+	}
+	cfg.OwletUser = user
+
+	pass, err := resolveSecret(cfg.OwletPass, cfg.PassFromEnv, "OWLET_PASS")
+	if err != nil {
+		return nil, fmt.Errorf("resolving owlet password: %w", err)
+	}
+	cfg.OwletPass = pass
+
+	if len(cfg.Accounts) == 0 {
+		cfg.Accounts = []AccountConfig{{
+			Name:      "default",
+			Region:    cfg.Region,
+			OwletUser: cfg.OwletUser,
+			OwletPass: cfg.OwletPass,
+		}}
+	} else {
+		for i := range cfg.Accounts {
+			acc := &cfg.Accounts[i]
+			if acc.Name == "" {
+				acc.Name = fmt.Sprintf("account-%d", i)
+			}
+			if acc.Region == "" {
+				acc.Region = cfg.Region
+			}
+			user, err := resolveSecret(acc.OwletUser, acc.UserFromEnv, "OWLET_USER")
+			if err != nil {
+				return nil, fmt.Errorf("resolving owlet user for account %q: %w", acc.Name, err)
+			}
+			acc.OwletUser = user
+
+			pass, err := resolveSecret(acc.OwletPass, acc.PassFromEnv, "OWLET_PASS")
+			if err != nil {
+				return nil, fmt.Errorf("resolving owlet password for account %q: %w", acc.Name, err)
+			}
+			acc.OwletPass = pass
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// vitalsMetricSpec drives registration and emission of one gauge derived
+// from a field of Vitals, so adding a new field to the struct only
+// requires one new entry here rather than a matching block in
+// recordVitals.
+type vitalsMetricSpec struct {
+	name  string
+	help  string
+	value func(v *Vitals) (float64, bool)
+}
+
+func intField(p *int) (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	return float64(*p), true
+}
+
+func floatField(p *float64) (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	return *p, true
+}
+
+// vitalsMetricSpecs covers every numeric field of Vitals except Alert,
+// which is decomposed into the per-bit gauges in alertBitSpecs instead of
+// being exported as a single opaque integer.
+var vitalsMetricSpecs = []vitalsMetricSpec{
+	{"aps", "Raw aps sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Aps) }},
+	{"base_battery_level", "Current base station battery level.", func(v *Vitals) (float64, bool) { return intField(v.BaseBattery) }},
+	{"bp", "Raw bp sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Bp) }},
+	{"bsb", "Raw bsb sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Bsb) }},
+	{"base_station_on", "Base station on status (1 for on, 0 for off).", func(v *Vitals) (float64, bool) { return intField(v.Bso) }},
+	{"sensor_battery", "Amount of minutes the sensor battery has left.", func(v *Vitals) (float64, bool) { return intField(v.SensorBattery) }},
+	{"charging_status", "Current charging status (1 for charging, 0 for not charging).", func(v *Vitals) (float64, bool) { return intField(v.Charging) }},
+	{"heart_rate", "Current heart rate.", func(v *Vitals) (float64, bool) { return intField(v.HeartRate) }},
+	{"mrs", "Raw mrs sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Mrs) }},
+	{"mst", "Raw mst sensor value.", func(v *Vitals) (float64, bool) { return floatField(v.Mst) }},
+	{"baby_movement", "How much is the baby moving.", func(v *Vitals) (float64, bool) { return intField(v.BabyMovement) }},
+	{"mvb", "Raw mvb sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Mvb) }},
+	{"onm", "Raw onm sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Onm) }},
+	{"ota", "Raw ota sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Ota) }},
+	{"oxygen_level", "Current oxygen level.", func(v *Vitals) (float64, bool) { return intField(v.Oxygen) }},
+	{"oxta", "Raw oxta sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Oxta) }},
+	{"rsi", "Raw rsi (signal strength) sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Rsi) }},
+	{"sb", "Raw sb sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Sb) }},
+	{"sc", "Raw sc sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Sc) }},
+	{"srf", "Raw srf sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Srf) }},
+	{"ss", "Raw ss (sleep state) sensor value.", func(v *Vitals) (float64, bool) { return intField(v.Ss) }},
+	{"st", "Raw st (sock status) sensor value.", func(v *Vitals) (float64, bool) { return intField(v.St) }},
+}
+
+// alertBitSpec names one bit of the Vitals.Alert bitmask. The layout below
+// is reverse engineered from the OwletCare app traffic and community
+// projects (python-owlet, homebridge-owlet) rather than documented by
+// Owlet, so treat bit positions past the well-known low ones as best
+// effort.
+type alertBitSpec struct {
+	bit  uint
+	name string
+	help string
+}
+
+var alertBitSpecs = []alertBitSpec{
+	{0, "low_battery", "Base station battery is critically low."},
+	{1, "lost_power", "Base station has lost AC power and is running on battery."},
+	{2, "not_worn", "Sock does not appear to be worn by the baby."},
+	{3, "sock_disconnected", "Sensor sock has lost its connection to the base station."},
+	{4, "spo2_low", "Oxygen saturation (SpO2) has dropped below the critical threshold."},
+	{5, "heart_rate_high", "Heart rate has risen above the critical threshold."},
+	{6, "heart_rate_low", "Heart rate has dropped below the critical threshold."},
+	{7, "ring_disconnected", "Base station has lost its Wi-Fi connection."},
+}
+
+var (
+	vitalsTimestamp *prometheus.GaugeVec
+	deviceInfo      *prometheus.GaugeVec
+	scrapeErrorsVec *prometheus.CounterVec
+	authFailuresVec *prometheus.CounterVec
+	circuitStateVec *prometheus.GaugeVec
+	pollDurationVec *prometheus.HistogramVec
+	alertGaugeVecs  = make(map[string]*prometheus.GaugeVec)
+)
+
+// registerVitalsMetrics registers a gauge for every entry in
+// vitalsMetricSpecs and alertBitSpecs, plus the handful of fixed metrics
+// that aren't per-vitals-field (last update timestamp, device info, scrape
+// errors). All of them carry an "account" label alongside "dsn" since a
+// process can run several Owlet accounts at once. Called once from init().
+func registerVitalsMetrics() {
+	for _, spec := range vitalsMetricSpecs {
+		vitalsGaugeVecs[spec.name] = newVitalsGauge(spec.name, spec.help)
+	}
+	for _, spec := range alertBitSpecs {
+		alertGaugeVecs[spec.name] = newVitalsGauge(fmt.Sprintf("alert_%s", spec.name), spec.help)
+	}
+
+	vitalsTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "owlet_vitals_last_update_timestamp_seconds",
+			Help: "Unix timestamp of the REAL_TIME_VITALS sample currently reported.",
+		},
+		[]string{"dsn", "account"},
+	)
+	prometheus.MustRegister(vitalsTimestamp)
+
+	deviceInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "owlet_device_info",
+			Help: "Always 1. Exposes device identity as labels for joining against other owlet_* metrics.",
+		},
+		[]string{"dsn", "account", "hardware"},
+	)
+	prometheus.MustRegister(deviceInfo)
+
+	scrapeErrorsVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "owlet_scrape_errors_total",
+			Help: "Total number of failed login/reactivate/fetchProps calls against the Ayla API.",
+		},
+		[]string{"account"},
+	)
+	prometheus.MustRegister(scrapeErrorsVec)
+
+	authFailuresVec = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "owlet_auth_failures_total",
+			Help: "Total number of login attempts that failed after exhausting retries.",
+		},
+		[]string{"account"},
+	)
+	prometheus.MustRegister(authFailuresVec)
+
+	circuitStateVec = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "owlet_circuit_state",
+			Help: "Poll circuit breaker state: 0=closed, 1=half-open, 2=open.",
+		},
+		[]string{"account"},
+	)
+	prometheus.MustRegister(circuitStateVec)
+
+	pollDurationVec = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "owlet_poll_duration_seconds",
+			Help:    "Duration of a full monitoring cycle (login + reactivate + fetch + record for every DSN).",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"account"},
+	)
+	prometheus.MustRegister(pollDurationVec)
+}
+
+// recordAlertBits sets one boolean gauge per bit of alrt so alerting rules
+// can target specific conditions instead of an opaque integer.
+func recordAlertBits(account, dsn string, alert int) {
+	for _, spec := range alertBitSpecs {
+		set := 0.0
+		if alert&(1<<spec.bit) != 0 {
+			set = 1.0
+		}
+		alertGaugeVecs[spec.name].With(prometheus.Labels{"dsn": dsn, "account": account}).Set(set)
+	}
+}
+
+// recordVitalsMetrics updates every Prometheus gauge derived from a
+// decoded Vitals sample: the per-field gauges, the alert bits, the device
+// info gauge, and the last-update timestamp.
+func recordVitalsMetrics(account, dsn string, vitals Vitals, dataUpdatedAt string) {
+	for _, spec := range vitalsMetricSpecs {
+		if v, ok := spec.value(&vitals); ok {
+			vitalsGaugeVecs[spec.name].With(prometheus.Labels{"dsn": dsn, "account": account}).Set(v)
+		}
+	}
+
+	if vitals.Alert != nil {
+		recordAlertBits(account, dsn, *vitals.Alert)
+	}
+
+	if vitals.Hardware != nil {
+		deviceInfo.With(prometheus.Labels{"dsn": dsn, "account": account, "hardware": *vitals.Hardware}).Set(1)
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, dataUpdatedAt); err == nil {
+		vitalsTimestamp.With(prometheus.Labels{"dsn": dsn, "account": account}).Set(float64(t.Unix()))
+	}
+}
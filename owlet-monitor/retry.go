@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	retryBaseDelay = 1 * time.Second
+	retryCapDelay  = 5 * time.Minute
+	retryAttempts  = 5
+)
+
+// errAuthExpired is wrapped into the error returned by a request function
+// that observed a 401 and cleared the account's auth token. withRetry
+// treats it as unrecoverable for the remaining attempts of this call: the
+// token is now known stale, so retrying against it would just fail the
+// same way on every attempt, burning the whole backoff schedule for
+// nothing. The next poll cycle's login() call will re-authenticate.
+var errAuthExpired = errors.New("auth token expired (401)")
+
+// backoffFullJitter returns the delay before retry attempt n (0-indexed),
+// using the "full jitter" strategy AWS recommends: a random value between
+// zero and the exponentially-growing, capped backoff.
+func backoffFullJitter(attempt int, base, cap time.Duration) time.Duration {
+	exp := base << attempt
+	if exp <= 0 || exp > cap { // shift overflowed, or past the cap
+		exp = cap
+	}
+	return time.Duration(rand.Int63n(int64(exp) + 1))
+}
+
+// withRetry calls fn up to retryAttempts times, sleeping a full-jittered
+// exponential backoff between attempts, and gives up early if ctx is
+// cancelled. It's used by login, fetchDSN, reactivate, and the properties
+// GET so a transient network error or 5xx from Ayla doesn't immediately
+// propagate to the caller.
+func withRetry(ctx context.Context, name string, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < retryAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if errors.Is(err, errAuthExpired) {
+			return fmt.Errorf("%s: aborting retries, auth token expired: %w", name, err)
+		}
+		if attempt == retryAttempts-1 {
+			break
+		}
+		delay := backoffFullJitter(attempt, retryBaseDelay, retryCapDelay)
+		log.Printf("%s failed (attempt %d/%d), retrying in %s: %v", name, attempt+1, retryAttempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("%s: giving up after %d attempts: %w", name, retryAttempts, err)
+}
+
+// circuitState is exported as owlet_circuit_state so operators can graph
+// breaker transitions.
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+// CircuitBreaker opens after a run of consecutive failures and refuses
+// further calls until cooldown has elapsed, at which point a single
+// half-open probe is let through; success closes the breaker again,
+// failure re-opens it for another cooldown period.
+type CircuitBreaker struct {
+	mu         sync.Mutex
+	threshold  int
+	cooldown   time.Duration
+	stateGauge prometheus.Gauge // pre-labelled with this breaker's account
+
+	state     circuitState
+	fails     int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, stateGauge prometheus.Gauge) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold:  threshold,
+		cooldown:   cooldown,
+		stateGauge: stateGauge,
+	}
+}
+
+// Allow reports whether a call should proceed, transitioning an open
+// breaker to half-open once the cooldown has elapsed.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == circuitOpen && time.Now().After(cb.openUntil) {
+		cb.state = circuitHalfOpen
+		cb.stateGauge.Set(float64(cb.state))
+	}
+	return cb.state != circuitOpen
+}
+
+// RecordResult updates the breaker based on the outcome of a call that
+// Allow permitted.
+func (cb *CircuitBreaker) RecordResult(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err == nil {
+		cb.fails = 0
+		cb.state = circuitClosed
+		cb.stateGauge.Set(float64(cb.state))
+		return
+	}
+
+	cb.fails++
+	if cb.state == circuitHalfOpen || cb.fails >= cb.threshold {
+		cb.state = circuitOpen
+		cb.openUntil = time.Now().Add(cb.cooldown)
+	}
+	cb.stateGauge.Set(float64(cb.state))
+}
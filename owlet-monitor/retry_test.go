@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBackoffFullJitter(t *testing.T) {
+	cases := []struct {
+		name    string
+		attempt int
+		base    time.Duration
+		cap     time.Duration
+	}{
+		{"first attempt stays within the base", 0, time.Second, time.Minute},
+		{"grows but stays within the cap", 3, time.Second, time.Minute},
+		{"clamps once the exponent passes the cap", 10, time.Second, time.Minute},
+		{"clamps on shift overflow for very large attempts", 100, time.Second, 5 * time.Minute},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The delay is randomised, so sample it repeatedly rather than
+			// asserting a single value.
+			for i := 0; i < 100; i++ {
+				d := backoffFullJitter(tc.attempt, tc.base, tc.cap)
+				if d < 0 || d > tc.cap {
+					t.Fatalf("backoffFullJitter(%d, %s, %s) = %s, want in [0, %s]", tc.attempt, tc.base, tc.cap, d, tc.cap)
+				}
+			}
+		})
+	}
+}
+
+func newTestCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_owlet_circuit_state"})
+	return newCircuitBreaker(threshold, cooldown, gauge)
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	cb := newTestCircuitBreaker(3, time.Minute)
+
+	if !cb.Allow() {
+		t.Fatal("breaker should start closed")
+	}
+
+	cb.RecordResult(errors.New("fail"))
+	cb.RecordResult(errors.New("fail"))
+	if !cb.Allow() {
+		t.Fatal("breaker should stay closed below the failure threshold")
+	}
+
+	cb.RecordResult(errors.New("fail")) // 3rd consecutive failure hits the threshold
+	if cb.Allow() {
+		t.Fatal("breaker should open once consecutive failures reach the threshold")
+	}
+}
+
+func TestCircuitBreakerRecoversOnSuccess(t *testing.T) {
+	cb := newTestCircuitBreaker(2, time.Minute)
+
+	cb.RecordResult(errors.New("fail"))
+	cb.RecordResult(nil) // success resets the streak before the threshold is hit
+	cb.RecordResult(errors.New("fail"))
+	if !cb.Allow() {
+		t.Fatal("a success should reset the consecutive-failure count")
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	cb := newTestCircuitBreaker(1, time.Minute)
+
+	cb.RecordResult(errors.New("fail"))
+	if cb.Allow() {
+		t.Fatal("breaker should be open after hitting the threshold")
+	}
+
+	// Simulate the cooldown elapsing instead of sleeping in the test.
+	cb.mu.Lock()
+	cb.openUntil = time.Now().Add(-time.Millisecond)
+	cb.mu.Unlock()
+
+	if !cb.Allow() {
+		t.Fatal("breaker should let a single probe through once cooldown elapses")
+	}
+	cb.mu.Lock()
+	state := cb.state
+	cb.mu.Unlock()
+	if state != circuitHalfOpen {
+		t.Fatalf("breaker state = %v, want circuitHalfOpen", state)
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	cb := newTestCircuitBreaker(1, time.Minute)
+
+	cb.RecordResult(errors.New("fail"))
+	cb.mu.Lock()
+	cb.openUntil = time.Now().Add(-time.Millisecond)
+	cb.mu.Unlock()
+	cb.Allow() // transition to half-open
+
+	cb.RecordResult(errors.New("fail")) // failing the probe re-opens immediately
+	if cb.Allow() {
+		t.Fatal("breaker should re-open on a failed half-open probe")
+	}
+}
+
+func TestCircuitBreakerHalfOpenSuccessCloses(t *testing.T) {
+	cb := newTestCircuitBreaker(1, time.Minute)
+
+	cb.RecordResult(errors.New("fail"))
+	cb.mu.Lock()
+	cb.openUntil = time.Now().Add(-time.Millisecond)
+	cb.mu.Unlock()
+	cb.Allow() // transition to half-open
+
+	cb.RecordResult(nil) // a successful probe closes the breaker again
+	if !cb.Allow() {
+		t.Fatal("breaker should be closed after a successful half-open probe")
+	}
+	cb.mu.Lock()
+	state := cb.state
+	cb.mu.Unlock()
+	if state != circuitClosed {
+		t.Fatalf("breaker state = %v, want circuitClosed", state)
+	}
+}
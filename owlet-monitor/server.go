@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newAPIServer wires /metrics and the HTTP API (status, devices, vitals,
+// events) onto a single listener, the same way syncthing's apiService
+// serves /rest/system/status, /rest/events, and /rest/system/ping
+// alongside its UI on one address.
+func newAPIServer(addr string, app *App) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", app.handleHealthz)
+	mux.HandleFunc("/readyz", app.handleReadyz)
+	mux.HandleFunc("/api/v1/devices", app.handleDevices)
+	mux.HandleFunc("/api/v1/vitals/", app.handleVitals)
+	mux.HandleFunc("/api/v1/events", app.handleEvents)
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleHealthz returns 503 while we don't hold a non-expired auth token,
+// i.e. before the first successful login or once a token has expired
+// without a successful re-auth.
+func (app *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !app.Authenticated() {
+		http.Error(w, "not authenticated", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz returns 503 until fetchDSN has discovered at least one
+// device.
+func (app *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !app.Ready() {
+		http.Error(w, "no devices discovered yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// handleDevices lists every discovered DSN and its last poll result.
+func (app *App) handleDevices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.Devices())
+}
+
+// handleVitals returns the most recent decoded Vitals for a single DSN.
+func (app *App) handleVitals(w http.ResponseWriter, r *http.Request) {
+	dsn := strings.TrimPrefix(r.URL.Path, "/api/v1/vitals/")
+	if dsn == "" {
+		http.Error(w, "dsn is required", http.StatusBadRequest)
+		return
+	}
+	state, ok := app.DeviceState(dsn)
+	if !ok {
+		http.Error(w, "unknown dsn", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// handleEvents streams a JSON DeviceEvent via SSE every time a device's
+// vitals change, until the client disconnects.
+func (app *App) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	events, unsubscribe := app.Subscribe()
+	defer unsubscribe()
+
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", payload)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}